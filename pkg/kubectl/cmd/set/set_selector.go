@@ -17,15 +17,24 @@ limitations under the License.
 package set
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 
 	"k8s.io/kubernetes/pkg/printers"
 
 	"github.com/spf13/cobra"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	"k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation"
@@ -35,6 +44,14 @@ import (
 	"k8s.io/kubernetes/pkg/kubectl/util/i18n"
 )
 
+// patchTypesByFlag maps the allowed --patch-type values to the patch type sent to the API server.
+var patchTypesByFlag = map[string]types.PatchType{
+	"strategic": types.StrategicMergePatchType,
+	"merge":     types.MergePatchType,
+	"json":      types.JSONPatchType,
+	"apply":     types.ApplyPatchType,
+}
+
 // SelectorOptions is the start of the data required to perform the operation.  As new fields are added, add them here instead of
 // referencing the cmd.Flags()
 type SelectorOptions struct {
@@ -42,12 +59,16 @@ type SelectorOptions struct {
 
 	PrintFlags *printers.PrintFlags
 
-	local       bool
-	dryrun      bool
-	all         bool
-	record      bool
-	changeCause string
-	output      string
+	local                   bool
+	dryrun                  bool
+	all                     bool
+	record                  bool
+	changeCause             string
+	output                  string
+	propagateTemplateLabels bool
+	patchType               types.PatchType
+	fieldManager            string
+	forceConflicts          bool
 
 	resources []string
 	selector  *metav1.LabelSelector
@@ -61,6 +82,50 @@ type SelectorOptions struct {
 	mapper  meta.RESTMapper
 }
 
+// NewSelectorOptions returns a SelectorOptions with its defaults set, ready to be driven either by a
+// cobra command (via Complete) or directly as a library: set a selector and resources with SetSelector
+// and SetResources, provide a resource.Builder with SetBuilder, then call Run.
+func NewSelectorOptions(out io.Writer) *SelectorOptions {
+	return &SelectorOptions{
+		PrintFlags: printers.NewPrintFlags("selector updated"),
+
+		patchType:    types.StrategicMergePatchType,
+		fieldManager: "kubectl-set-selector",
+
+		out: out,
+	}
+}
+
+// SetSelector sets the label selector to apply to the targeted resources.
+func (o *SelectorOptions) SetSelector(selector *metav1.LabelSelector) {
+	o.selector = selector
+}
+
+// SetResources sets the <resource>/<name> (or <resource> <name>) arguments identifying which objects
+// to update. Not used when resources are supplied via SetBuilder from an in-memory manifest stream.
+func (o *SelectorOptions) SetResources(resources ...string) {
+	o.resources = resources
+}
+
+// SetLocal sets whether the command should avoid contacting the API server and instead operate purely
+// on the resources produced by the configured builder.
+func (o *SelectorOptions) SetLocal(local bool) {
+	o.local = local
+}
+
+// SetDryRun sets whether the command should print the mutated object instead of sending it to the
+// API server.
+func (o *SelectorOptions) SetDryRun(dryRun bool) {
+	o.dryrun = dryRun
+}
+
+// SetBuilder lets a library caller supply its own resource.Builder, e.g. one backed by a fake
+// RESTMapper and client in tests, or one that streams manifests from memory instead of querying a live
+// API server.
+func (o *SelectorOptions) SetBuilder(builder *resource.Builder) {
+	o.builder = builder
+}
+
 var (
 	selectorLong = templates.LongDesc(`
 		Set the selector on a resource. Note that the new selector will overwrite the old selector if the resource had one prior to the invocation
@@ -68,7 +133,13 @@ var (
 
 		A selector must begin with a letter or number, and may contain letters, numbers, hyphens, dots, and underscores, up to %[1]d characters.
 		If --resource-version is specified, then updates will use this resource version, otherwise the existing resource-version will be used.
-        Note: currently selectors can only be set on Service objects.`)
+        Note: selectors can be set on Services, Deployments, StatefulSets, DaemonSets, ReplicaSets, Jobs, CronJobs and custom resources. The selector on a
+        workload controller is immutable once set, so changing it on a live object requires --local or --dry-run combined with
+        'kubectl replace --force'. Pass --propagate-template-labels to also apply the new selector's match labels to the pod template so
+        the selector keeps matching the pods it creates. --patch-type selects how the update is sent to the server: strategic (default),
+        merge, json, or apply for server-side apply. Custom resources without a built-in Go type are supported generically through their
+        spec.selector and spec.template.metadata.labels fields; since they have no strategic merge schema, target them with
+        --patch-type=merge, json, or apply.`)
 	selectorExample = templates.Examples(`
         # set the labels and selector before creating a deployment/service pair.
         kubectl create service clusterip my-svc --clusterip="None" -o yaml --dry-run | kubectl set selector --local -f - 'environment=qa' -o yaml | kubectl create -f -
@@ -77,11 +148,7 @@ var (
 
 // NewCmdSelector is the "set selector" command.
 func NewCmdSelector(f cmdutil.Factory, out io.Writer) *cobra.Command {
-	options := &SelectorOptions{
-		PrintFlags: printers.NewPrintFlags("selector updated"),
-
-		out: out,
-	}
+	options := NewSelectorOptions(out)
 
 	cmd := &cobra.Command{
 		Use: "selector (-f FILENAME | TYPE NAME) EXPRESSIONS [--resource-version=version]",
@@ -92,7 +159,7 @@ func NewCmdSelector(f cmdutil.Factory, out io.Writer) *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			cmdutil.CheckErr(options.Complete(f, cmd, args))
 			cmdutil.CheckErr(options.Validate())
-			cmdutil.CheckErr(options.RunSelector())
+			cmdutil.CheckErr(options.Run(context.TODO()))
 		},
 	}
 
@@ -100,6 +167,10 @@ func NewCmdSelector(f cmdutil.Factory, out io.Writer) *cobra.Command {
 
 	cmd.Flags().Bool("all", false, "Select all resources, including uninitialized ones, in the namespace of the specified resource types")
 	cmd.Flags().Bool("local", false, "If true, set selector will NOT contact api-server but run locally.")
+	cmd.Flags().Bool("propagate-template-labels", false, "If true, also set the selector's match labels on the pod template so the selector keeps matching the pods it creates. Only applies to workload controllers, not Services.")
+	cmd.Flags().String("patch-type", "strategic", "The type of patch being provided; one of [strategic merge json apply]")
+	cmd.Flags().String("field-manager", "kubectl-set-selector", "Name of the manager used to track field ownership. Only relevant when --patch-type=apply.")
+	cmd.Flags().Bool("force-conflicts", false, "If true, server-side apply will force the changes against conflicts. Only valid when --patch-type=apply.")
 	cmd.Flags().String("resource-version", "", "If non-empty, the selectors update will only succeed if this is the current resource-version for the object. Only valid when specifying a single resource.")
 	usage := "the resource to update the selectors"
 	cmdutil.AddFilenameOptionFlags(cmd, &options.fileOptions, usage)
@@ -110,13 +181,25 @@ func NewCmdSelector(f cmdutil.Factory, out io.Writer) *cobra.Command {
 	return cmd
 }
 
-// Complete assigns the SelectorOptions from args.
+// Complete assigns the SelectorOptions from args. It is a thin wrapper around the setter-based library
+// API: it exists to translate cobra flags and a cmdutil.Factory into the same calls a library caller
+// would make directly.
 func (o *SelectorOptions) Complete(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
-	o.local = cmdutil.GetFlagBool(cmd, "local")
+	o.SetLocal(cmdutil.GetFlagBool(cmd, "local"))
 	o.all = cmdutil.GetFlagBool(cmd, "all")
 	o.record = cmdutil.GetRecordFlag(cmd)
-	o.dryrun = cmdutil.GetDryRunFlag(cmd)
+	o.SetDryRun(cmdutil.GetDryRunFlag(cmd))
 	o.output = cmdutil.GetFlagString(cmd, "output")
+	o.propagateTemplateLabels = cmdutil.GetFlagBool(cmd, "propagate-template-labels")
+	o.fieldManager = cmdutil.GetFlagString(cmd, "field-manager")
+	o.forceConflicts = cmdutil.GetFlagBool(cmd, "force-conflicts")
+
+	patchTypeFlag := cmdutil.GetFlagString(cmd, "patch-type")
+	patchType, ok := patchTypesByFlag[patchTypeFlag]
+	if !ok {
+		return fmt.Errorf("invalid --patch-type %q, must be one of [strategic merge json apply]", patchTypeFlag)
+	}
+	o.patchType = patchType
 
 	cmdNamespace, enforceNamespace, err := f.DefaultNamespace()
 	if err != nil {
@@ -127,10 +210,12 @@ func (o *SelectorOptions) Complete(f cmdutil.Factory, cmd *cobra.Command, args [
 	mapper, _ := f.Object()
 	o.mapper = mapper
 
-	o.resources, o.selector, err = getResourcesAndSelector(args)
+	resources, selector, err := getResourcesAndSelector(args)
 	if err != nil {
 		return err
 	}
+	o.SetResources(resources...)
+	o.SetSelector(selector)
 
 	includeUninitialized := cmdutil.ShouldIncludeUninitialized(cmd, false)
 	o.builder = f.NewBuilder().
@@ -178,23 +263,46 @@ func (o *SelectorOptions) Validate() error {
 	if o.selector == nil {
 		return fmt.Errorf("one selector is required")
 	}
+	if o.patchType == types.JSONPatchType && o.propagateTemplateLabels {
+		return fmt.Errorf("--propagate-template-labels is not supported with --patch-type=json")
+	}
+	if o.forceConflicts && o.patchType != types.ApplyPatchType {
+		return fmt.Errorf("--force-conflicts is only valid with --patch-type=apply")
+	}
 	return nil
 }
 
-// RunSelector executes the command.
-func (o *SelectorOptions) RunSelector() error {
+// Run executes the selector update against whatever resource.Builder was configured by Complete or
+// SetBuilder. It is the library entry point: embedders that don't go through cobra can construct a
+// SelectorOptions with NewSelectorOptions, drive it with the Set* methods and SetBuilder, and call Run
+// directly.
+func (o *SelectorOptions) Run(ctx context.Context) error {
+	if o.builder == nil {
+		return fmt.Errorf("SelectorOptions.Run: no resource.Builder configured; call Complete or SetBuilder first")
+	}
+	if o.PrintObj == nil {
+		printer, err := o.PrintFlags.ToPrinter()
+		if err != nil {
+			return err
+		}
+		o.PrintObj = printer.PrintObj
+	}
 	r := o.builder.Do()
 	err := r.Err()
 	if err != nil {
 		return err
 	}
 
+	// A selector can only be mutated in place on an already-persisted workload controller when we are not
+	// going to talk to the API server: the field is immutable server-side, so the real PATCH would just fail.
+	allowImmutableSelectorMutation := o.local || o.dryrun
+
 	return r.Visit(func(info *resource.Info, err error) error {
 		patch := &Patch{Info: info}
 		CalculatePatch(patch, cmdutil.InternalVersionJSONEncoder(), func(info *resource.Info) ([]byte, error) {
 			versioned := info.AsVersioned()
 			patch.Info.Object = versioned
-			selectErr := updateSelectorForObject(info.Object, *o.selector)
+			selectErr := updateSelectorForObject(info.Object, *o.selector, o.propagateTemplateLabels, allowImmutableSelectorMutation)
 
 			if selectErr == nil {
 				return runtime.Encode(cmdutil.InternalVersionJSONEncoder(), info.Object)
@@ -209,7 +317,41 @@ func (o *SelectorOptions) RunSelector() error {
 			return o.PrintObj(info.Object, o.out)
 		}
 
-		patched, err := resource.NewHelper(info.Client, info.Mapping).Patch(info.Namespace, info.Name, types.StrategicMergePatchType, patch.Patch)
+		if _, isUnstructured := patch.Info.Object.(*unstructured.Unstructured); isUnstructured && o.patchType == types.StrategicMergePatchType {
+			return fmt.Errorf("%s has no strategic merge schema to patch against; re-run with --patch-type=merge, json, or apply", info.Mapping.GroupVersionKind.Kind)
+		}
+
+		if o.patchType == types.ApplyPatchType {
+			patched, err := o.applySelector(info, patch.Info.Object)
+			if err != nil {
+				return err
+			}
+			info.Refresh(patched, true)
+			return o.PrintObj(patch.Info.AsVersioned(), o.out)
+		}
+
+		data := patch.Patch
+		switch o.patchType {
+		case types.JSONPatchType:
+			data, err = jsonPatchForSelector(patch.Info.Object, *o.selector)
+			if err != nil {
+				return err
+			}
+		case types.MergePatchType:
+			// patch.Patch is a strategic merge patch, which isn't guaranteed to be valid RFC 7396
+			// JSON merge patch content once lists are involved, so build a dedicated merge body
+			// instead of sending the strategic bytes under a different Content-Type.
+			mergeObj, mergeErr := minimalSelectorObject(patch.Info.Object, o.propagateTemplateLabels)
+			if mergeErr != nil {
+				return mergeErr
+			}
+			data, err = runtime.Encode(cmdutil.InternalVersionJSONEncoder(), mergeObj)
+			if err != nil {
+				return err
+			}
+		}
+
+		patched, err := resource.NewHelper(info.Client, info.Mapping).Patch(info.Namespace, info.Name, o.patchType, data)
 		if err != nil {
 			return err
 		}
@@ -227,7 +369,158 @@ func (o *SelectorOptions) RunSelector() error {
 	})
 }
 
-func updateSelectorForObject(obj runtime.Object, selector metav1.LabelSelector) error {
+// applySelector sends a server-side apply patch scoped to only the fields set selector actually
+// changed, folding the record annotation into the same request instead of following up with a
+// Replace call. The applied body deliberately omits every other field on the live object: applying
+// the full object would claim ownership of all of it, putting this field manager in permanent
+// conflict with whatever else (e.g. a CI pipeline's `kubectl apply`) manages those fields.
+func (o *SelectorOptions) applySelector(info *resource.Info, mutated runtime.Object) (runtime.Object, error) {
+	applyObj, err := minimalSelectorObject(mutated, o.propagateTemplateLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.record || cmdutil.ContainsChangeCause(info) {
+		if err := cmdutil.RecordChangeCause(applyObj, o.changeCause); err != nil {
+			return nil, fmt.Errorf("changes to %s/%s can't be recorded: %v", info.Mapping.Resource, info.Name, err)
+		}
+	}
+
+	data, err := runtime.Encode(cmdutil.InternalVersionJSONEncoder(), applyObj)
+	if err != nil {
+		return nil, err
+	}
+
+	client := info.Client
+	if o.ClientForMapping != nil {
+		if client, err = o.ClientForMapping(info.Mapping); err != nil {
+			return nil, err
+		}
+	}
+
+	req := client.Patch(types.ApplyPatchType).
+		NamespaceIfScoped(info.Namespace, info.Mapping.Scope.Name() == meta.RESTScopeNameNamespace).
+		Resource(info.Mapping.Resource).
+		Name(info.Name).
+		Param("fieldManager", o.fieldManager).
+		Param("force", strconv.FormatBool(o.forceConflicts)).
+		Body(data)
+
+	result := req.Do()
+	if err := result.Error(); err != nil {
+		return nil, err
+	}
+	return result.Get()
+}
+
+// minimalSelectorObject builds a manifest containing only apiVersion/kind, metadata name/namespace, and
+// the mutated selector (and, when propagateTemplateLabels is set, the pod template labels) from mutated.
+// It backs both --patch-type=apply and --patch-type=merge: server-side apply claims ownership of every
+// field present in the applied body, and a JSON merge patch (RFC 7396) replaces whatever top-level keys
+// it's given, so in both cases scoping the body down to just the fields set selector changed keeps this
+// command from clobbering or claiming ownership of the rest of the object.
+//
+// The result is always built as an *unstructured.Unstructured, never as mutated's own Go type: a typed
+// struct still serializes its other required (non-omitempty) fields -- e.g. the pod template's container
+// list -- as explicit JSON nulls, which a merge patch would read as "delete this field" and server-side
+// apply would claim ownership of. Building a plain map sidesteps that, since a key nothing ever set is
+// simply absent, and it doubles as the generic path custom resources with no Go type need anyway.
+func minimalSelectorObject(mutated runtime.Object, propagateTemplateLabels bool) (runtime.Object, error) {
+	accessor, err := meta.Accessor(mutated)
+	if err != nil {
+		return nil, err
+	}
+	gvk := mutated.GetObjectKind().GroupVersionKind()
+
+	selectorPath := []string{"spec", "selector"}
+	labelsPath := []string{"spec", "template", "metadata", "labels"}
+	if _, ok := mutated.(*batchv1beta1.CronJob); ok {
+		selectorPath = []string{"spec", "jobTemplate", "spec", "selector"}
+		labelsPath = []string{"spec", "jobTemplate", "spec", "template", "metadata", "labels"}
+	}
+
+	var content map[string]interface{}
+	if u, ok := mutated.(*unstructured.Unstructured); ok {
+		content = u.Object
+	} else {
+		content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(mutated)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	minimal := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	minimal.SetAPIVersion(gvk.GroupVersion().String())
+	minimal.SetKind(gvk.Kind)
+	minimal.SetName(accessor.GetName())
+	minimal.SetNamespace(accessor.GetNamespace())
+
+	selectorVal, found, err := unstructured.NestedMap(content, selectorPath...)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err := unstructured.SetNestedMap(minimal.Object, selectorVal, selectorPath...); err != nil {
+			return nil, err
+		}
+	}
+	if propagateTemplateLabels {
+		labels, found, err := unstructured.NestedStringMap(content, labelsPath...)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			if err := unstructured.SetNestedStringMap(minimal.Object, labels, labelsPath...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return minimal, nil
+}
+
+// jsonPatchForSelector builds a minimal RFC 6902 JSON patch that sets the selector field at the path
+// appropriate for obj's kind. It uses "add" rather than "replace": "replace" requires the target path
+// to already exist, but a Service created without a selector (or any workload object whose selector is
+// an omitempty nil) has no /spec/selector key yet, which is exactly the "first time set" case this
+// command needs to support.
+func jsonPatchForSelector(obj runtime.Object, selector metav1.LabelSelector) ([]byte, error) {
+	path, ok := selectorJSONPatchPath(obj)
+	if !ok {
+		return nil, fmt.Errorf("--patch-type=json is not supported for %T", obj)
+	}
+	var value interface{} = selector
+	if _, isService := obj.(*v1.Service); isService {
+		// A Service's spec.selector is a plain label map, not a LabelSelector.
+		value = selector.MatchLabels
+	}
+	ops := []map[string]interface{}{
+		{"op": "add", "path": path, "value": value},
+	}
+	return json.Marshal(ops)
+}
+
+// selectorJSONPatchPath returns the JSON pointer path to the mutable selector field for obj's kind.
+func selectorJSONPatchPath(obj runtime.Object) (string, bool) {
+	switch obj.(type) {
+	case *v1.Service, *extensionsv1beta1.DaemonSet, *extensionsv1beta1.ReplicaSet, *appsv1beta1.Deployment, *appsv1beta1.StatefulSet, *batchv1.Job, *unstructured.Unstructured:
+		return "/spec/selector", true
+	case *batchv1beta1.CronJob:
+		return "/spec/jobTemplate/spec/selector", true
+	}
+	return "", false
+}
+
+// SelectorImmutableError is returned when a selector update is attempted against an already-persisted
+// object whose selector field cannot be changed via PATCH/UPDATE once set.
+type SelectorImmutableError struct {
+	Kind string
+}
+
+func (e SelectorImmutableError) Error() string {
+	return fmt.Sprintf("the selector is immutable once set on %s objects; re-run with --local or --dry-run and pipe the result to `kubectl replace --force` instead", e.Kind)
+}
+
+func updateSelectorForObject(obj runtime.Object, selector metav1.LabelSelector, propagateTemplateLabels, allowImmutableMutation bool) error {
 	copyOldSelector := func() (map[string]string, error) {
 		if len(selector.MatchExpressions) > 0 {
 			return nil, fmt.Errorf("match expression %v not supported on this object", selector.MatchExpressions)
@@ -238,16 +531,90 @@ func updateSelectorForObject(obj runtime.Object, selector metav1.LabelSelector)
 		}
 		return dst, nil
 	}
+	// apply sets *dst to a copy of selector, optionally propagating its match labels onto *templateLabels
+	// so the selector keeps matching the pod template. If *dst is already set to a different selector,
+	// the field is immutable server-side, so it errors unless allowImmutableMutation allows producing
+	// local/dry-run output for a `kubectl replace --force` pipeline.
+	apply := func(dst **metav1.LabelSelector, templateLabels *map[string]string, kind string) error {
+		if *dst != nil && !apiequality.Semantic.DeepEqual(*dst, &selector) && !allowImmutableMutation {
+			return SelectorImmutableError{Kind: kind}
+		}
+		copied := selector
+		*dst = &copied
+		if propagateTemplateLabels {
+			if *templateLabels == nil {
+				*templateLabels = map[string]string{}
+			}
+			for label, value := range selector.MatchLabels {
+				(*templateLabels)[label] = value
+			}
+		}
+		return nil
+	}
 	var err error
 	switch t := obj.(type) {
 	case *v1.Service:
 		t.Spec.Selector, err = copyOldSelector()
+	case *extensionsv1beta1.DaemonSet:
+		err = apply(&t.Spec.Selector, &t.Spec.Template.Labels, "DaemonSet")
+	case *extensionsv1beta1.ReplicaSet:
+		err = apply(&t.Spec.Selector, &t.Spec.Template.Labels, "ReplicaSet")
+	case *appsv1beta1.Deployment:
+		err = apply(&t.Spec.Selector, &t.Spec.Template.Labels, "Deployment")
+	case *appsv1beta1.StatefulSet:
+		err = apply(&t.Spec.Selector, &t.Spec.Template.Labels, "StatefulSet")
+	case *batchv1.Job:
+		err = apply(&t.Spec.Selector, &t.Spec.Template.Labels, "Job")
+	case *batchv1beta1.CronJob:
+		err = apply(&t.Spec.JobTemplate.Spec.Selector, &t.Spec.JobTemplate.Spec.Template.Labels, "CronJob")
+	case *unstructured.Unstructured:
+		err = applyUnstructuredSelector(t, selector, propagateTemplateLabels, allowImmutableMutation)
 	default:
-		err = fmt.Errorf("setting a selector is only supported for Services")
+		err = fmt.Errorf("setting a selector is only supported for Services, Deployments, StatefulSets, DaemonSets, ReplicaSets, Jobs, CronJobs and custom resources")
 	}
 	return err
 }
 
+// applyUnstructuredSelector mirrors the apply closure in updateSelectorForObject for objects with no
+// built-in Go type, i.e. CRDs. It reads and writes spec.selector and spec.template.metadata.labels
+// generically through the unstructured content, which is what lets --patch-type=merge/json/apply target
+// resources this command has no registered type for; strategic merge isn't available for them since
+// that requires the built-in schema.
+func applyUnstructuredSelector(obj *unstructured.Unstructured, selector metav1.LabelSelector, propagateTemplateLabels, allowImmutableMutation bool) error {
+	selectorMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&selector)
+	if err != nil {
+		return err
+	}
+
+	existing, found, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if err != nil {
+		return err
+	}
+	if found && !apiequality.Semantic.DeepEqual(existing, selectorMap) && !allowImmutableMutation {
+		return SelectorImmutableError{Kind: obj.GetKind()}
+	}
+	if err := unstructured.SetNestedMap(obj.Object, selectorMap, "spec", "selector"); err != nil {
+		return err
+	}
+
+	if propagateTemplateLabels {
+		labels, _, err := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+		if err != nil {
+			return err
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for label, value := range selector.MatchLabels {
+			labels[label] = value
+		}
+		if err := unstructured.SetNestedStringMap(obj.Object, labels, "spec", "template", "metadata", "labels"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // getResourcesAndSelector retrieves resources and the selector expression from the given args (assuming selectors the last arg)
 func getResourcesAndSelector(args []string) (resources []string, selector *metav1.LabelSelector, err error) {
 	if len(args) == 0 {
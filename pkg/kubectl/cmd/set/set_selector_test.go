@@ -0,0 +1,460 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package set
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest/fake"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
+	cmdtesting "k8s.io/kubernetes/pkg/kubectl/cmd/testing"
+	"k8s.io/kubernetes/pkg/kubectl/resource"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestUpdateSelectorForWorkloadControllers(t *testing.T) {
+	tests := []struct {
+		name                    string
+		manifest                string
+		selector                *metav1.LabelSelector
+		propagateTemplateLabels bool
+		wantErr                 string
+		wantOutputContains      []string
+	}{
+		{
+			name: "deployment selector is set and propagated to the pod template",
+			manifest: `{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"nginx","namespace":"test"},
+				"spec":{"template":{"metadata":{"labels":{"app":"nginx"}},"spec":{"containers":[{"name":"nginx","image":"nginx"}]}}}}`,
+			selector:                &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx", "tier": "frontend"}},
+			propagateTemplateLabels: true,
+			wantOutputContains:      []string{`"tier":"frontend"`, `"tier": "frontend"`},
+		},
+		{
+			name: "deployment accepts match expressions",
+			manifest: `{"apiVersion":"apps/v1beta1","kind":"Deployment","metadata":{"name":"nginx","namespace":"test"},
+				"spec":{"template":{"metadata":{"labels":{"app":"nginx"}},"spec":{"containers":[{"name":"nginx","image":"nginx"}]}}}}`,
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"nginx"}},
+			}},
+			wantOutputContains: []string{`"matchExpressions"`},
+		},
+		{
+			name: "service selector rejects match expressions",
+			manifest: `{"apiVersion":"v1","kind":"Service","metadata":{"name":"nginx","namespace":"test"},
+				"spec":{"ports":[{"port":80}]}}`,
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"nginx"}},
+			}},
+			wantErr: "not supported on this object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tf := cmdtesting.NewTestFactory().WithNamespace("test")
+			defer tf.Cleanup()
+
+			buf := &bytes.Buffer{}
+			o := NewSelectorOptions(buf)
+			o.SetSelector(tt.selector)
+			o.SetLocal(true)
+			o.propagateTemplateLabels = tt.propagateTemplateLabels
+			o.PrintFlags.OutputFormat = strPtr("json")
+			printer, err := o.PrintFlags.ToPrinter()
+			if err != nil {
+				t.Fatalf("ToPrinter: %v", err)
+			}
+			o.PrintObj = printer.PrintObj
+
+			o.SetBuilder(tf.NewBuilder().
+				Internal().
+				LocalParam(true).
+				Stream(strings.NewReader(tt.manifest), "manifest").
+				Flatten())
+
+			err = o.Run(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() returned unexpected error: %v", err)
+			}
+
+			out := buf.String()
+			for _, want := range tt.wantOutputContains {
+				if strings.Contains(out, want) {
+					return
+				}
+			}
+			t.Fatalf("expected output to contain one of %v, got:\n%s", tt.wantOutputContains, out)
+		})
+	}
+}
+
+// deploymentResponse returns the Deployment served back for both the initial GET and the PATCH/apply
+// response, so the fake client round-trips the same object the test set up. It's encoded as
+// apps/v1beta1, matching the only Deployment type updateSelectorForObject and selectorJSONPatchPath
+// switch on; encoding as extensions/v1beta1 here would silently exercise a code path set selector
+// doesn't actually support for Deployments.
+func deploymentResponse(t *testing.T, dep *appsv1beta1.Deployment) *http.Response {
+	codec := legacyscheme.Codecs.LegacyCodec(appsv1beta1.SchemeGroupVersion)
+	data, err := runtime.Encode(codec, dep)
+	if err != nil {
+		t.Fatalf("failed to encode fixture deployment: %v", err)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(data)),
+	}
+}
+
+// TestSelectorOptionsRunAgainstFakeClient drives Run() with SetLocal(false) through a fake RESTClient
+// for every --patch-type, exercising applySelector's request construction (field-manager/force) and the
+// non-local PATCH/apply path that TestUpdateSelectorForWorkloadControllers, which only runs --local, never
+// touches.
+func TestSelectorOptionsRunAgainstFakeClient(t *testing.T) {
+	tests := []struct {
+		name            string
+		patchType       string
+		wantMethod      string
+		wantContentType string
+		wantApplyVerb   bool
+		checkBody       func(t *testing.T, body []byte)
+	}{
+		{name: "strategic merge patch", patchType: "strategic", wantMethod: "PATCH", wantContentType: string(types.StrategicMergePatchType)},
+		{
+			name:            "merge patch",
+			patchType:       "merge",
+			wantMethod:      "PATCH",
+			wantContentType: string(types.MergePatchType),
+			checkBody: func(t *testing.T, body []byte) {
+				var obj map[string]interface{}
+				if err := json.Unmarshal(body, &obj); err != nil {
+					t.Fatalf("merge patch body is not a JSON object: %v\nbody: %s", err, body)
+				}
+				spec, ok := obj["spec"].(map[string]interface{})
+				if !ok {
+					t.Fatalf("expected merge patch body to have a spec object, got:\n%s", body)
+				}
+				selector, ok := spec["selector"].(map[string]interface{})
+				if !ok {
+					t.Fatalf("expected merge patch body's spec to have a selector object, got:\n%s", body)
+				}
+				matchLabels, _ := selector["matchLabels"].(map[string]interface{})
+				if matchLabels["tier"] != "frontend" {
+					t.Fatalf("expected merge patch selector to contain tier=frontend, got:\n%s", body)
+				}
+				if strings.Contains(string(body), `"image"`) {
+					t.Fatalf("expected merge patch body to omit unrelated fields like the container image, got:\n%s", body)
+				}
+			},
+		},
+		{
+			name:            "json patch",
+			patchType:       "json",
+			wantMethod:      "PATCH",
+			wantContentType: string(types.JSONPatchType),
+			checkBody: func(t *testing.T, body []byte) {
+				var ops []map[string]interface{}
+				if err := json.Unmarshal(body, &ops); err != nil {
+					t.Fatalf("json patch body is not a JSON array: %v\nbody: %s", err, body)
+				}
+				if len(ops) != 1 {
+					t.Fatalf("expected exactly one JSON Patch operation, got %d:\n%s", len(ops), body)
+				}
+				if ops[0]["op"] != "add" {
+					t.Fatalf(`expected op "add" (so the patch also works when /spec/selector is absent), got:\n%s`, body)
+				}
+				if ops[0]["path"] != "/spec/selector" {
+					t.Fatalf("expected path /spec/selector, got:\n%s", body)
+				}
+				if _, ok := ops[0]["value"]; !ok {
+					t.Fatalf("expected op to carry the new selector as its value, got:\n%s", body)
+				}
+			},
+		},
+		{name: "server-side apply", patchType: "apply", wantMethod: "PATCH", wantApplyVerb: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := &appsv1beta1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "test"},
+				Spec: appsv1beta1.DeploymentSpec{
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}},
+						Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "nginx", Image: "nginx"}}},
+					},
+				},
+			}
+
+			var gotReq *http.Request
+			var gotBody []byte
+			tf := cmdtesting.NewTestFactory().WithNamespace("test")
+			defer tf.Cleanup()
+			tf.Client = &fake.RESTClient{
+				GroupVersion:         appsv1beta1.SchemeGroupVersion,
+				NegotiatedSerializer: legacyscheme.Codecs,
+				Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+					gotReq = req
+					switch req.Method {
+					case http.MethodGet:
+						return deploymentResponse(t, dep), nil
+					case http.MethodPatch:
+						body, err := ioutil.ReadAll(req.Body)
+						if err != nil {
+							t.Fatalf("failed to read request body: %v", err)
+						}
+						gotBody = body
+						return deploymentResponse(t, dep), nil
+					default:
+						t.Fatalf("unexpected request: %s %s", req.Method, req.URL)
+						return nil, nil
+					}
+				}),
+			}
+			tf.ClientConfigVal = cmdtesting.DefaultClientConfig()
+
+			buf := &bytes.Buffer{}
+			o := NewSelectorOptions(buf)
+			o.SetSelector(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx", "tier": "frontend"}})
+			o.SetLocal(false)
+			o.patchType = patchTypesByFlag[tt.patchType]
+			o.ClientForMapping = func(mapping *meta.RESTMapping) (resource.RESTClient, error) {
+				return tf.Client, nil
+			}
+			o.PrintFlags.OutputFormat = strPtr("json")
+			printer, err := o.PrintFlags.ToPrinter()
+			if err != nil {
+				t.Fatalf("ToPrinter: %v", err)
+			}
+			o.PrintObj = printer.PrintObj
+
+			o.SetBuilder(tf.NewBuilder().
+				Internal().
+				LocalParam(false).
+				NamespaceParam("test").DefaultNamespace().
+				ResourceTypeOrNameArgs(false, "deployment", "nginx").
+				Latest().
+				Flatten())
+
+			if err := o.Run(context.Background()); err != nil {
+				t.Fatalf("Run() returned unexpected error: %v", err)
+			}
+
+			if gotReq == nil || gotReq.Method != tt.wantMethod {
+				t.Fatalf("expected a %s request, got %#v", tt.wantMethod, gotReq)
+			}
+			if tt.wantContentType != "" {
+				if got := gotReq.Header.Get("Content-Type"); got != tt.wantContentType {
+					t.Fatalf("expected Content-Type %q, got %q", tt.wantContentType, got)
+				}
+			}
+			if tt.checkBody != nil {
+				tt.checkBody(t, gotBody)
+			}
+			if tt.wantApplyVerb {
+				if got := gotReq.URL.Query().Get("fieldManager"); got != "kubectl-set-selector" {
+					t.Fatalf("expected fieldManager=kubectl-set-selector on the apply request, got %q", got)
+				}
+				if got := gotReq.URL.Query().Get("force"); got != "false" {
+					t.Fatalf("expected force=false on the apply request, got %q", got)
+				}
+				// The applied body must be scoped to the selector/template-labels fields set selector
+				// changed, not the full fetched object: applying the full object would claim ownership
+				// of every field on it (e.g. the container image below), putting this field manager in
+				// permanent conflict with whatever else manages the rest of the Deployment.
+				if strings.Contains(string(gotBody), `"image"`) {
+					t.Fatalf("expected apply body to omit unrelated fields like the container image, got:\n%s", gotBody)
+				}
+				if !strings.Contains(string(gotBody), `"tier":"frontend"`) {
+					t.Fatalf("expected apply body to contain the new selector, got:\n%s", gotBody)
+				}
+			}
+
+			// The printed object round-trips through AsVersioned(), so its apiVersion confirms which
+			// concrete Deployment type (apps/v1beta1, matching the production switch) Run() actually
+			// patched, rather than relying on the fixture's type alone.
+			if !strings.Contains(buf.String(), "apps/v1beta1") {
+				t.Fatalf("expected printed output to come back as apps/v1beta1, got:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+// TestSelectorOptionsRunImmutableSelector checks that, against a fake client, changing the selector of
+// an already-persisted Deployment without --local/--dry-run is rejected with SelectorImmutableError
+// instead of being sent to the API server.
+func TestSelectorOptionsRunImmutableSelector(t *testing.T) {
+	dep := &appsv1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "test"},
+		Spec: appsv1beta1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "nginx", Image: "nginx"}}},
+			},
+		},
+	}
+
+	tf := cmdtesting.NewTestFactory().WithNamespace("test")
+	defer tf.Cleanup()
+	tf.Client = &fake.RESTClient{
+		GroupVersion:         appsv1beta1.SchemeGroupVersion,
+		NegotiatedSerializer: legacyscheme.Codecs,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet {
+				t.Fatalf("expected only a GET request, got: %s %s", req.Method, req.URL)
+			}
+			return deploymentResponse(t, dep), nil
+		}),
+	}
+	tf.ClientConfigVal = cmdtesting.DefaultClientConfig()
+
+	o := NewSelectorOptions(&bytes.Buffer{})
+	o.SetSelector(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx", "tier": "frontend"}})
+	o.SetLocal(false)
+	o.SetBuilder(tf.NewBuilder().
+		Internal().
+		LocalParam(false).
+		NamespaceParam("test").DefaultNamespace().
+		ResourceTypeOrNameArgs(false, "deployment", "nginx").
+		Latest().
+		Flatten())
+
+	err := o.Run(context.Background())
+	immutableErr, ok := err.(SelectorImmutableError)
+	if !ok {
+		t.Fatalf("expected SelectorImmutableError, got %v", err)
+	}
+	// Confirms dispatch actually matched the *appsv1beta1.Deployment case in updateSelectorForObject
+	// rather than falling through to the generic "not supported" error for an unrecognized type.
+	if immutableErr.Kind != "Deployment" {
+		t.Fatalf("expected SelectorImmutableError for kind Deployment, got %q", immutableErr.Kind)
+	}
+}
+
+// TestUpdateSelectorForUnstructuredCRD confirms that a CRD with no built-in Go type, represented as
+// *unstructured.Unstructured, can still get a selector set through updateSelectorForObject and that the
+// generic path produces patch bodies consistent with minimalSelectorObject/jsonPatchForSelector's built-in
+// handling -- this is what lets --patch-type=merge/json/apply (which don't need a strategic merge
+// schema) target CRDs, per selectorLong's note that strategic merge isn't available for them.
+func TestUpdateSelectorForUnstructuredCRD(t *testing.T) {
+	newWidget := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Widget",
+			"metadata": map[string]interface{}{
+				"name":      "my-widget",
+				"namespace": "test",
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(3),
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{"app": "widget"},
+					},
+				},
+			},
+		}}
+	}
+	selector := metav1.LabelSelector{MatchLabels: map[string]string{"app": "widget", "tier": "frontend"}}
+
+	widget := newWidget()
+	if err := updateSelectorForObject(widget, selector, true, false); err != nil {
+		t.Fatalf("updateSelectorForObject on a CRD returned unexpected error: %v", err)
+	}
+	gotSelector, found, err := unstructured.NestedStringMap(widget.Object, "spec", "selector", "matchLabels")
+	if err != nil || !found {
+		t.Fatalf("expected spec.selector.matchLabels to be set, found=%v err=%v", found, err)
+	}
+	if gotSelector["tier"] != "frontend" {
+		t.Fatalf("expected selector to contain tier=frontend, got %v", gotSelector)
+	}
+	gotLabels, found, err := unstructured.NestedStringMap(widget.Object, "spec", "template", "metadata", "labels")
+	if err != nil || !found || gotLabels["tier"] != "frontend" {
+		t.Fatalf("expected propagateTemplateLabels to set spec.template.metadata.labels.tier=frontend, got %v found=%v err=%v", gotLabels, found, err)
+	}
+
+	// A second call with a different selector and allowImmutableMutation=false must be rejected, exactly
+	// like the built-in workload controllers.
+	if err := updateSelectorForObject(widget, metav1.LabelSelector{MatchLabels: map[string]string{"other": "selector"}}, false, false); err == nil {
+		t.Fatal("expected changing an already-set CRD selector without --local/--dry-run to be rejected")
+	} else if _, ok := err.(SelectorImmutableError); !ok {
+		t.Fatalf("expected SelectorImmutableError for a CRD, got %v (%T)", err, err)
+	}
+
+	path, ok := selectorJSONPatchPath(widget)
+	if !ok || path != "/spec/selector" {
+		t.Fatalf("expected selectorJSONPatchPath to return /spec/selector for a CRD, got %q, %v", path, ok)
+	}
+	if _, err := jsonPatchForSelector(widget, selector); err != nil {
+		t.Fatalf("jsonPatchForSelector on a CRD returned unexpected error: %v", err)
+	}
+
+	minimal, err := minimalSelectorObject(widget, true)
+	if err != nil {
+		t.Fatalf("minimalSelectorObject on a CRD returned unexpected error: %v", err)
+	}
+	minimalU, ok := minimal.(*unstructured.Unstructured)
+	if !ok {
+		t.Fatalf("expected minimalSelectorObject to return *unstructured.Unstructured, got %T", minimal)
+	}
+	if minimalU.GetName() != "my-widget" || minimalU.GetNamespace() != "test" {
+		t.Fatalf("expected the minimal CRD object to keep name/namespace, got %s/%s", minimalU.GetNamespace(), minimalU.GetName())
+	}
+	if _, found, _ := unstructured.NestedInt64(minimalU.Object, "spec", "replicas"); found {
+		t.Fatalf("expected the minimal CRD object to omit unrelated spec fields like replicas, got %v", minimalU.Object)
+	}
+}
+
+func TestSelectorOptionsRunRequiresBuilder(t *testing.T) {
+	o := NewSelectorOptions(&bytes.Buffer{})
+	o.SetSelector(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}})
+
+	if err := o.Run(context.Background()); err == nil {
+		t.Fatal("expected Run() without a configured builder to return an error")
+	}
+}
+
+func TestSelectorImmutableErrorMessage(t *testing.T) {
+	err := SelectorImmutableError{Kind: "Deployment"}
+	if !strings.Contains(err.Error(), "Deployment") {
+		t.Fatalf("expected error message to mention the kind, got %q", err.Error())
+	}
+	if !strings.Contains(err.Error(), "--local") {
+		t.Fatalf("expected error message to mention the --local workaround, got %q", err.Error())
+	}
+}